@@ -0,0 +1,228 @@
+// GoToSocial
+// Copyright (C) GoToSocial Authors admin@gotosocial.org
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	gostore "codeberg.org/gruf/go-store/v2/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/superseriousbusiness/gotosocial/internal/config"
+)
+
+// azureStorage implements storage.Storage (and PresignedURLer) on
+// top of an Azure Blob Storage container, so it can be plugged into
+// a Driver the same way local disk or S3 storage can.
+type azureStorage struct {
+	client    *azblob.Client
+	container string
+}
+
+func (a *azureStorage) ReadBytes(ctx context.Context, key string) ([]byte, error) {
+	rc, err := a.ReadStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func (a *azureStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := a.client.DownloadStream(ctx, a.container, key, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("azure: error downloading blob %s: %w", key, err)
+	}
+
+	return resp.Body, nil
+}
+
+func (a *azureStorage) WriteBytes(ctx context.Context, key string, value []byte) (int, error) {
+	_, err := a.client.UploadBuffer(ctx, a.container, key, value, nil)
+	if err != nil {
+		return 0, fmt.Errorf("azure: error uploading blob %s: %w", key, err)
+	}
+	return len(value), nil
+}
+
+func (a *azureStorage) WriteStream(ctx context.Context, key string, r io.Reader) (int64, error) {
+	counter := &countingReader{r: r}
+	_, err := a.client.UploadStream(ctx, a.container, key, counter, nil)
+	if err != nil {
+		return 0, fmt.Errorf("azure: error uploading blob stream %s: %w", key, err)
+	}
+	return counter.n, nil
+}
+
+func (a *azureStorage) Remove(ctx context.Context, key string) error {
+	_, err := a.client.DeleteBlob(ctx, a.container, key, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("azure: error deleting blob %s: %w", key, err)
+	}
+	return nil
+}
+
+func (a *azureStorage) Stat(ctx context.Context, key string) (bool, error) {
+	_, err := a.client.ServiceClient().NewContainerClient(a.container).NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("azure: error statting blob %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (a *azureStorage) WalkKeys(ctx context.Context, opts gostore.WalkKeysOptions) error {
+	pager := a.client.NewListBlobsFlatPager(a.container, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("azure: error listing blobs: %w", err)
+		}
+		for _, item := range page.Segment.BlobItems {
+			if err := opts.WalkFn(ctx, gostore.Entry{Key: *item.Name}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *azureStorage) Close() error {
+	// Nothing to close; the SDK client holds no long-lived connections.
+	return nil
+}
+
+// PresignedURL generates a SAS (Shared Access Signature) URL granting
+// read-only access to key, valid for the given expiry duration.
+func (a *azureStorage) PresignedURL(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	blobClient := a.client.ServiceClient().NewContainerClient(a.container).NewBlobClient(key)
+
+	permissions := sas.BlobPermissions{Read: true}
+	start := time.Now().Add(-5 * time.Minute) // allow for clock skew
+	expiresOn := time.Now().Add(expiry)
+
+	sasURL, err := blobClient.GetSASURL(permissions, expiresOn, &blob.GetSASURLOptions{StartTime: &start})
+	if err != nil {
+		return nil, fmt.Errorf("azure: error generating SAS url for %s: %w", key, err)
+	}
+
+	return url.Parse(sasURL)
+}
+
+func (a *azureStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := a.client.DownloadStream(ctx, a.container, key, &azblob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset, Count: length},
+	})
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("azure: error downloading blob range %s: %w", key, err)
+	}
+
+	return resp.Body, nil
+}
+
+func (a *azureStorage) StatInfo(ctx context.Context, key string) (Info, error) {
+	props, err := a.client.ServiceClient().NewContainerClient(a.container).NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		if bloberror.HasCode(err, bloberror.BlobNotFound) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, fmt.Errorf("azure: error statting blob %s: %w", key, err)
+	}
+
+	var size int64
+	if props.ContentLength != nil {
+		size = *props.ContentLength
+	}
+
+	var modTime time.Time
+	if props.LastModified != nil {
+		modTime = *props.LastModified
+	}
+
+	var contentType string
+	if props.ContentType != nil {
+		contentType = *props.ContentType
+	}
+
+	return Info{Size: size, ModTime: modTime, ContentType: contentType}, nil
+}
+
+// countingReader wraps an io.Reader and tracks the total number
+// of bytes read through it, so WriteStream can report byte count
+// the same way the other backends do.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// NewAzureStorage returns a new Driver backed by Azure Blob Storage,
+// using the configured storage account and container.
+func NewAzureStorage() (*Driver, error) {
+	account := config.GetStorageAzureAccountName()
+	key := config.GetStorageAzureAccountKey()
+	container := config.GetStorageAzureContainer()
+
+	cred, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating azure credential: %w", err)
+	}
+
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	client, err := azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating azure client: %w", err)
+	}
+
+	return &Driver{
+		Proxy:  config.GetStorageAzureProxy(),
+		Bucket: container,
+		Storage: &azureStorage{
+			client:    client,
+			container: container,
+		},
+		PresignedCache: newPresignedCache(),
+	}, nil
+}
+
+func init() {
+	RegisterBackend("azure", NewAzureStorage)
+}
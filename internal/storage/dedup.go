@@ -0,0 +1,439 @@
+// GoToSocial
+// Copyright (C) GoToSocial Authors admin@gotosocial.org
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gostore "codeberg.org/gruf/go-store/v2/storage"
+	"github.com/superseriousbusiness/gotosocial/internal/config"
+)
+
+const (
+	// dedupBlobPrefix is where content-addressed blobs live,
+	// keyed by the hex-encoded hash of their contents.
+	dedupBlobPrefix = "blobs/"
+
+	// dedupRefSuffix stores the reference count for a blob,
+	// as a plain decimal string, alongside the blob itself.
+	dedupRefSuffix = ".refcount"
+
+	// dedupPointerPrefix marks a logical key's value as a pointer
+	// to a deduplicated blob, rather than the object's actual
+	// contents. Keys written before deduplication was enabled (or
+	// not yet visited by the migration) won't carry this prefix,
+	// so reads/deletes fall back to treating them as raw objects.
+	dedupPointerPrefix = "gts-dedup-ptr:1:"
+)
+
+// dedupStorage wraps a storage.Storage implementation to
+// content-address writes: PutStream hashes the incoming data and
+// stores it once at blobs/<hash>, with the logical key instead
+// holding a small pointer to that blob. Repeated writes of the same
+// content (eg. the same remote media boosted/cached many times)
+// therefore only occupy storage once.
+type dedupStorage struct {
+	inner gostore.Storage
+
+	// refMu serializes store and Remove against each other, so that a
+	// write and a delete racing on the same key (or two writes of the
+	// same content) can't resolve the same stale blob pointer and
+	// step on each other's increment/decrement.
+	refMu sync.Mutex
+}
+
+// dedupStorageSigner adds PresignedURLer support on top of
+// dedupStorage, resolving the logical key to its blob first. Unlike
+// GetRange/StatInfo (which can always fall back to reading the whole
+// object), there's no way to fake a presigned URL for a backend that
+// doesn't support one, so this is only embedded into the returned
+// storage.Storage when inner actually implements PresignedURLer -
+// otherwise dedupStorage's plain (PresignedURLer-less) type is
+// returned, so a type assertion against it correctly fails instead of
+// reaching into a Driver.PresignedCache that may not exist for this
+// backend (see NewFileStorage, which never sets one).
+type dedupStorageSigner struct {
+	*dedupStorage
+	signer PresignedURLer
+}
+
+func newDedupStorage(inner gostore.Storage) gostore.Storage {
+	d := &dedupStorage{inner: inner}
+
+	if signer, ok := inner.(PresignedURLer); ok {
+		return &dedupStorageSigner{dedupStorage: d, signer: signer}
+	}
+
+	return d
+}
+
+func blobKey(sum []byte) string {
+	return dedupBlobPrefix + hex.EncodeToString(sum)
+}
+
+// refcount reads the current reference count for a blob, treating
+// a missing sidecar as a count of zero (ie. the blob doesn't exist yet).
+func (d *dedupStorage) refcount(ctx context.Context, blob string) (int, error) {
+	raw, err := d.inner.ReadBytes(ctx, blob+dedupRefSuffix)
+	if err != nil {
+		if err == ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("storage: corrupt refcount for %s: %w", blob, err)
+	}
+
+	return n, nil
+}
+
+func (d *dedupStorage) setRefcount(ctx context.Context, blob string, n int) error {
+	_, err := d.inner.WriteBytes(ctx, blob+dedupRefSuffix, []byte(strconv.Itoa(n)))
+	return err
+}
+
+// store writes value at blobKey if no blob with that hash exists yet,
+// then increments the blob's refcount and points key at it. If key
+// already pointed at a (different) blob, that blob's reference is
+// dropped first, so re-Putting an existing key doesn't leak it.
+func (d *dedupStorage) store(ctx context.Context, key string, value []byte) error {
+	sum := sha256.Sum256(value)
+	blob := blobKey(sum[:])
+
+	d.refMu.Lock()
+	defer d.refMu.Unlock()
+
+	prevBlob, wasPointer, err := d.resolve(ctx, key)
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if wasPointer {
+		if prevBlob == blob {
+			// Re-Putting identical content at the same key: it's
+			// already referenced once for this key, so incrementing
+			// again below would inflate the count with nothing to
+			// balance it, and the blob would never be freed.
+			return nil
+		}
+
+		if err := d.dropRef(ctx, prevBlob); err != nil {
+			return err
+		}
+	}
+
+	count, err := d.refcount(ctx, blob)
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		if _, err := d.inner.WriteBytes(ctx, blob, value); err != nil {
+			return err
+		}
+	}
+
+	if err := d.setRefcount(ctx, blob, count+1); err != nil {
+		return err
+	}
+
+	_, err = d.inner.WriteBytes(ctx, key, []byte(dedupPointerPrefix+blob))
+	return err
+}
+
+// dropRef decrements blob's refcount, removing the blob and its
+// refcount sidecar once no logical key references it any longer.
+// Callers must hold refMu.
+func (d *dedupStorage) dropRef(ctx context.Context, blob string) error {
+	count, err := d.refcount(ctx, blob)
+	if err != nil {
+		return err
+	}
+
+	count--
+	if count > 0 {
+		return d.setRefcount(ctx, blob, count)
+	}
+
+	// Last reference gone; remove the blob and its refcount sidecar.
+	if err := d.inner.Remove(ctx, blob+dedupRefSuffix); err != nil {
+		return err
+	}
+	return d.inner.Remove(ctx, blob)
+}
+
+// resolve returns the blob key that the logical key points to, and
+// whether it was actually a pointer at all. Keys that predate
+// deduplication (or haven't been migrated yet) aren't pointers, and
+// should be treated as holding their contents directly.
+func (d *dedupStorage) resolve(ctx context.Context, key string) (blob string, isPointer bool, err error) {
+	raw, err := d.inner.ReadBytes(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+
+	s := string(raw)
+	if !strings.HasPrefix(s, dedupPointerPrefix) {
+		return "", false, nil
+	}
+
+	return strings.TrimPrefix(s, dedupPointerPrefix), true, nil
+}
+
+func (d *dedupStorage) ReadBytes(ctx context.Context, key string) ([]byte, error) {
+	blob, isPointer, err := d.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !isPointer {
+		return d.inner.ReadBytes(ctx, key)
+	}
+	return d.inner.ReadBytes(ctx, blob)
+}
+
+func (d *dedupStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	blob, isPointer, err := d.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !isPointer {
+		return d.inner.ReadStream(ctx, key)
+	}
+	return d.inner.ReadStream(ctx, blob)
+}
+
+func (d *dedupStorage) WriteBytes(ctx context.Context, key string, value []byte) (int, error) {
+	if err := d.store(ctx, key, value); err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
+// WriteStream reads the incoming stream fully into a buffer and then
+// hashes the buffered data (in store), so the content address is only
+// known once the stream is exhausted. The data has to be buffered
+// until then, since we can't choose the blob's key (and therefore know
+// whether we even need to write it) before we've seen all of it.
+func (d *dedupStorage) WriteStream(ctx context.Context, key string, r io.Reader) (int64, error) {
+	buf := new(bytes.Buffer)
+
+	n, err := io.Copy(buf, r)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.store(ctx, key, buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Remove deletes the pointer at key, decrementing the underlying
+// blob's refcount and only removing the blob itself once no logical
+// key references it any longer.
+func (d *dedupStorage) Remove(ctx context.Context, key string) error {
+	d.refMu.Lock()
+	defer d.refMu.Unlock()
+
+	blob, isPointer, err := d.resolve(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if err := d.inner.Remove(ctx, key); err != nil {
+		return err
+	}
+
+	if !isPointer {
+		return nil
+	}
+
+	return d.dropRef(ctx, blob)
+}
+
+func (d *dedupStorage) Stat(ctx context.Context, key string) (bool, error) {
+	return d.inner.Stat(ctx, key)
+}
+
+// WalkKeys walks only logical keys, skipping the blobs/ namespace
+// which holds implementation detail (content-addressed blobs and
+// their refcount sidecars) rather than anything callers asked to store.
+func (d *dedupStorage) WalkKeys(ctx context.Context, opts gostore.WalkKeysOptions) error {
+	return d.inner.WalkKeys(ctx, gostore.WalkKeysOptions{
+		WalkFn: func(ctx context.Context, entry gostore.Entry) error {
+			if strings.HasPrefix(entry.Key, dedupBlobPrefix) {
+				return nil
+			}
+			return opts.WalkFn(ctx, entry)
+		},
+	})
+}
+
+func (d *dedupStorage) Close() error {
+	return d.inner.Close()
+}
+
+// PresignedURL resolves key to the blob it actually points at, then
+// forwards to the signer inner was wrapped with. The logical key only
+// ever holds a tiny pointer, so a presigned URL for it directly would
+// serve that pointer instead of the real content.
+func (d *dedupStorageSigner) PresignedURL(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	blob, isPointer, err := d.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if isPointer {
+		key = blob
+	}
+
+	return d.signer.PresignedURL(ctx, key, expiry)
+}
+
+// GetRange forwards to the wrapped storage's RangeReader, if it has
+// one, after resolving key to the blob it actually points at.
+// Otherwise it falls back to fetching the whole (blob) object and
+// discarding/limiting around the range, the same way Driver.GetRange
+// does - without this, dedupStorage's own GetRange method would
+// always satisfy the RangeReader interface regardless of what it
+// wraps, permanently shadowing that fallback for anything dedup sits
+// in front of (eg. encryptedStorage, which doesn't support ranges).
+func (d *dedupStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	blob, isPointer, err := d.resolve(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if isPointer {
+		key = blob
+	}
+
+	if rr, ok := d.inner.(RangeReader); ok {
+		return rr.GetRange(ctx, key, offset, length)
+	}
+
+	rc, err := d.inner.ReadStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return readCloser{
+		Reader: io.LimitReader(rc, length),
+		Closer: rc,
+	}, nil
+}
+
+// StatInfo forwards to the wrapped storage's InfoStater, if it has
+// one, after resolving key to the blob it actually points at.
+// Otherwise, like GetRange above, it falls back to reading the whole
+// (blob) object just to report its size, rather than shadowing
+// Driver.Stat's own "backend does not support Stat" error with a
+// dedup-specific one that's reachable via the exact same bug.
+func (d *dedupStorage) StatInfo(ctx context.Context, key string) (Info, error) {
+	blob, isPointer, err := d.resolve(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+	if isPointer {
+		key = blob
+	}
+
+	if is, ok := d.inner.(InfoStater); ok {
+		return is.StatInfo(ctx, key)
+	}
+
+	raw, err := d.inner.ReadBytes(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Size: int64(len(raw))}, nil
+}
+
+// maybeWrapDedup wraps d.Storage in a dedupStorage if
+// storage-dedupe-enabled is configured on, so that new writes made
+// through the Driver are content-addressed and deduplicated.
+//
+// It's applied after encryption wrapping (if any) in AutoConfig, so
+// deduplication happens on plaintext, before objects get encrypted;
+// deduplicating ciphertext wouldn't collapse anything, since the
+// same plaintext encrypted twice produces different ciphertext.
+func maybeWrapDedup(d *Driver) {
+	if !config.GetStorageDedupeEnabled() {
+		return
+	}
+	d.Storage = newDedupStorage(d.Storage)
+}
+
+// MigrateDedup walks every existing key in s and collapses duplicate
+// content into single blobs, rewriting each key it visits as a
+// pointer. It's intended to be run once, via an admin subcommand,
+// after switching an existing instance's storage over to
+// storage-dedupe-enabled, so that objects stored before the change
+// are deduplicated too, rather than only new ones.
+func MigrateDedup(ctx context.Context, s gostore.Storage) (int, error) {
+	dedup := &dedupStorage{inner: s}
+
+	var collapsed int
+	err := s.WalkKeys(ctx, gostore.WalkKeysOptions{
+		WalkFn: func(ctx context.Context, entry gostore.Entry) error {
+			if strings.HasPrefix(entry.Key, dedupBlobPrefix) {
+				return nil
+			}
+
+			value, err := s.ReadBytes(ctx, entry.Key)
+			if err != nil {
+				return fmt.Errorf("error reading %s: %w", entry.Key, err)
+			}
+
+			if strings.HasPrefix(string(value), dedupPointerPrefix) {
+				// Already migrated.
+				return nil
+			}
+
+			if err := dedup.store(ctx, entry.Key, value); err != nil {
+				return fmt.Errorf("error deduplicating %s: %w", entry.Key, err)
+			}
+
+			collapsed++
+			return nil
+		},
+	})
+	if err != nil {
+		return collapsed, err
+	}
+
+	return collapsed, nil
+}
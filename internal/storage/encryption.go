@@ -0,0 +1,328 @@
+// GoToSocial
+// Copyright (C) GoToSocial Authors admin@gotosocial.org
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	gostore "codeberg.org/gruf/go-store/v2/storage"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/superseriousbusiness/gotosocial/internal/config"
+	"github.com/superseriousbusiness/gotosocial/internal/log"
+)
+
+const (
+	// encryptionMagic identifies an object as having been
+	// written by encryptedStorage, so that a bad key-id or a
+	// mismatched (unencrypted) object can be rejected clearly
+	// rather than failing deep inside GCM with a confusing error.
+	encryptionMagic = "GTSE"
+
+	// encryptionVersion covers the on-disk header layout, so
+	// it can be revised later (eg. a different AEAD) without
+	// breaking objects written by older versions.
+	encryptionVersion = 1
+
+	nonceSize  = 12 // standard AES-GCM nonce size
+	keySize    = 32 // AES-256
+	gcmTagSize = 16 // standard AES-GCM authentication tag size
+
+	// maxKeyIDLen is the largest key-id encrypt can record in the
+	// object header, since its length is written as a single byte.
+	maxKeyIDLen = 255
+)
+
+// encryptedStorage wraps a storage.Storage implementation to transparently
+// encrypt objects at rest with AES-256-GCM. The data-encryption key is
+// derived per key-id (not per-object) via HKDF from the configured master
+// key, and a random nonce is generated for every write.
+//
+// Each stored object carries a small header (magic, version, key-id,
+// nonce) ahead of the ciphertext, so that the key-id used to encrypt it
+// travels with the object and keys can be rotated without needing to
+// rewrite every existing object under the old key.
+type encryptedStorage struct {
+	inner     gostore.Storage
+	masterKey []byte
+	keyID     string
+}
+
+// newEncryptedStorage returns a storage.Storage that encrypts everything
+// written through it, and transparently decrypts everything read back.
+// masterKey must be keySize (32) bytes; keyID is stored alongside each
+// object so the correct key can be derived again on read/rotation.
+func newEncryptedStorage(inner gostore.Storage, masterKey []byte, keyID string) (*encryptedStorage, error) {
+	if len(masterKey) != keySize {
+		return nil, fmt.Errorf("storage: encryption key must be %d bytes, have %d", keySize, len(masterKey))
+	}
+
+	if len(keyID) > maxKeyIDLen {
+		return nil, fmt.Errorf("storage: encryption key-id must be at most %d bytes, have %d", maxKeyIDLen, len(keyID))
+	}
+
+	return &encryptedStorage{
+		inner:     inner,
+		masterKey: masterKey,
+		keyID:     keyID,
+	}, nil
+}
+
+// deriveKey derives a per-key-id AES-256 key from the master key using
+// HKDF, so that the master key is never used directly for encryption
+// and different key-ids can be rotated in independently.
+func deriveKey(masterKey []byte, keyID string) ([]byte, error) {
+	key := make([]byte, keySize)
+	kdf := hkdf.New(sha256.New, masterKey, nil, []byte(keyID))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, fmt.Errorf("error deriving key for key-id %s: %w", keyID, err)
+	}
+	return key, nil
+}
+
+func newGCM(masterKey []byte, keyID string) (cipher.AEAD, error) {
+	key, err := deriveKey(masterKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypt seals plaintext under the encryptedStorage's current key-id,
+// prepending the object header so it can be decrypted (and the key-id
+// identified) later.
+func (e *encryptedStorage) encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(e.masterKey, e.keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(encryptionMagic)
+	buf.WriteByte(encryptionVersion)
+	buf.WriteByte(byte(len(e.keyID)))
+	buf.WriteString(e.keyID)
+	buf.Write(nonce)
+	buf.Write(gcm.Seal(nil, nonce, plaintext, nil))
+
+	return buf.Bytes(), nil
+}
+
+// decrypt reverses encrypt, reading the key-id and nonce back out of the
+// object header so the correct (possibly rotated) key can be derived.
+func (e *encryptedStorage) decrypt(data []byte) ([]byte, error) {
+	r := bytes.NewReader(data)
+
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != encryptionMagic {
+		return nil, fmt.Errorf("storage: object is not an encrypted GtS object (bad magic)")
+	}
+
+	version, err := r.ReadByte()
+	if err != nil || version != encryptionVersion {
+		return nil, fmt.Errorf("storage: unsupported encryption header version")
+	}
+
+	keyIDLen, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("storage: truncated encryption header")
+	}
+
+	keyID := make([]byte, keyIDLen)
+	if _, err := io.ReadFull(r, keyID); err != nil {
+		return nil, fmt.Errorf("storage: truncated encryption header")
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, fmt.Errorf("storage: truncated encryption header")
+	}
+
+	gcm, err := newGCM(e.masterKey, string(keyID))
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("storage: error decrypting object (wrong key-id %q?): %w", keyID, err)
+	}
+
+	return plaintext, nil
+}
+
+func (e *encryptedStorage) ReadBytes(ctx context.Context, key string) ([]byte, error) {
+	raw, err := e.inner.ReadBytes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(raw)
+}
+
+// ReadStream decrypts and buffers the whole object before returning it.
+// GCM authenticates the object as a single unit, so there's no way to
+// verify (and therefore no way to safely return) a prefix of the
+// plaintext before the whole ciphertext has been read and checked.
+func (e *encryptedStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := e.inner.ReadStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := e.decrypt(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(plaintext)), nil
+}
+
+func (e *encryptedStorage) WriteBytes(ctx context.Context, key string, value []byte) (int, error) {
+	ciphertext, err := e.encrypt(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := e.inner.WriteBytes(ctx, key, ciphertext); err != nil {
+		return 0, err
+	}
+
+	// Report the plaintext length written, same as the unencrypted path.
+	return len(value), nil
+}
+
+func (e *encryptedStorage) WriteStream(ctx context.Context, key string, r io.Reader) (int64, error) {
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := e.WriteBytes(ctx, key, value)
+	return int64(n), err
+}
+
+func (e *encryptedStorage) Remove(ctx context.Context, key string) error {
+	return e.inner.Remove(ctx, key)
+}
+
+func (e *encryptedStorage) Stat(ctx context.Context, key string) (bool, error) {
+	return e.inner.Stat(ctx, key)
+}
+
+// StatInfo reports the plaintext size of the object at key, derived from
+// the wrapped backend's (ciphertext) size minus the header and GCM tag
+// overhead, so that callers like HTTP Range handling can learn an
+// encrypted object's real size without reading and decrypting it.
+//
+// The overhead is computed using the storage's current key-id length,
+// so Stat will report a slightly wrong size for objects written under
+// a since-rotated key-id of a different length; this is a known
+// limitation of stat-without-reading and doesn't affect Get/GetStream,
+// which read the object's own header regardless of key-id length.
+func (e *encryptedStorage) StatInfo(ctx context.Context, key string) (Info, error) {
+	is, ok := e.inner.(InfoStater)
+	if !ok {
+		return Info{}, fmt.Errorf("storage: underlying backend does not support Stat")
+	}
+
+	info, err := is.StatInfo(ctx, key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	overhead := int64(len(encryptionMagic)) + 2 + int64(len(e.keyID)) + nonceSize + gcmTagSize
+	info.Size -= overhead
+	info.ContentType = "" // ciphertext's stat can't tell us the plaintext's real type
+
+	return info, nil
+}
+
+func (e *encryptedStorage) WalkKeys(ctx context.Context, opts gostore.WalkKeysOptions) error {
+	return e.inner.WalkKeys(ctx, opts)
+}
+
+func (e *encryptedStorage) Close() error {
+	return e.inner.Close()
+}
+
+// maybeWrapEncryption wraps d.Storage in an encryptedStorage if
+// storage-encryption-key is configured, forcing proxying on so that
+// Driver.URL can never hand out a presigned URL to ciphertext.
+//
+// This relies on encryptedStorage not implementing PresignedURLer, so
+// Driver.URL's type assertion always fails once wrapped; d.Proxy is
+// also set explicitly so the invariant holds even if a future backend
+// nested another layer that satisfies PresignedURLer around it.
+func maybeWrapEncryption(d *Driver) error {
+	hexKey := config.GetStorageEncryptionKey()
+	if hexKey == "" {
+		return nil
+	}
+
+	masterKey, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return fmt.Errorf("storage-encryption-key is not valid hex: %w", err)
+	}
+
+	keyID := config.GetStorageEncryptionKeyID()
+
+	enc, err := newEncryptedStorage(d.Storage, masterKey, keyID)
+	if err != nil {
+		return err
+	}
+
+	if !d.Proxy {
+		log.Warn(context.Background(), "storage encryption is enabled; forcing storage proxying on, "+
+			"since presigned URLs would otherwise serve ciphertext directly to clients")
+	}
+
+	d.Storage = enc
+	d.Proxy = true
+
+	return nil
+}
@@ -0,0 +1,95 @@
+// GoToSocial
+// Copyright (C) GoToSocial Authors admin@gotosocial.org
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, keySize)
+}
+
+func TestEncryptedStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemStorage()
+
+	enc, err := newEncryptedStorage(inner, testMasterKey(0x42), "key-1")
+	if err != nil {
+		t.Fatalf("newEncryptedStorage: %v", err)
+	}
+
+	plaintext := []byte("some object content")
+	if _, err := enc.WriteBytes(ctx, "obj", plaintext); err != nil {
+		t.Fatalf("WriteBytes: %v", err)
+	}
+
+	raw, err := inner.ReadBytes(ctx, "obj")
+	if err != nil {
+		t.Fatalf("reading stored object: %v", err)
+	}
+	if bytes.Equal(raw, plaintext) {
+		t.Fatalf("object should be stored as ciphertext, not plaintext")
+	}
+
+	got, err := enc.ReadBytes(ctx, "obj")
+	if err != nil {
+		t.Fatalf("ReadBytes: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypted content = %q, want %q", got, plaintext)
+	}
+}
+
+func TestEncryptedStorageRejectsWrongKey(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemStorage()
+
+	enc, err := newEncryptedStorage(inner, testMasterKey(0x42), "key-1")
+	if err != nil {
+		t.Fatalf("newEncryptedStorage: %v", err)
+	}
+	if _, err := enc.WriteBytes(ctx, "obj", []byte("secret")); err != nil {
+		t.Fatalf("WriteBytes: %v", err)
+	}
+
+	wrongKey, err := newEncryptedStorage(inner, testMasterKey(0x99), "key-1")
+	if err != nil {
+		t.Fatalf("newEncryptedStorage: %v", err)
+	}
+
+	if _, err := wrongKey.ReadBytes(ctx, "obj"); err == nil {
+		t.Fatalf("expected decrypting with the wrong master key to fail")
+	}
+}
+
+func TestNewEncryptedStorageRejectsOversizedKeyID(t *testing.T) {
+	longKeyID := string(bytes.Repeat([]byte("a"), maxKeyIDLen+1))
+
+	if _, err := newEncryptedStorage(newMemStorage(), testMasterKey(0x42), longKeyID); err == nil {
+		t.Fatalf("expected a key-id over %d bytes to be rejected", maxKeyIDLen)
+	}
+}
+
+func TestNewEncryptedStorageRejectsBadKeySize(t *testing.T) {
+	if _, err := newEncryptedStorage(newMemStorage(), []byte("too-short"), "key-1"); err == nil {
+		t.Fatalf("expected a non-%d-byte master key to be rejected", keySize)
+	}
+}
@@ -0,0 +1,208 @@
+// GoToSocial
+// Copyright (C) GoToSocial Authors admin@gotosocial.org
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/storage"
+	gostore "codeberg.org/gruf/go-store/v2/storage"
+	"github.com/superseriousbusiness/gotosocial/internal/config"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsStorage implements storage.Storage (and PresignedURLer) on top
+// of a Google Cloud Storage bucket, so it can be plugged into a
+// Driver the same way local disk or S3 storage can.
+type gcsStorage struct {
+	client         *storage.Client
+	bucket         string
+	serviceAccount string // email, required to sign URLs when using ADC
+}
+
+func (g *gcsStorage) object(key string) *storage.ObjectHandle {
+	return g.client.Bucket(g.bucket).Object(key)
+}
+
+func (g *gcsStorage) ReadBytes(ctx context.Context, key string) ([]byte, error) {
+	rc, err := g.ReadStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func (g *gcsStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	rc, err := g.object(key).NewReader(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("gcs: error reading object %s: %w", key, err)
+	}
+	return rc, nil
+}
+
+func (g *gcsStorage) WriteBytes(ctx context.Context, key string, value []byte) (int, error) {
+	n, err := g.WriteStream(ctx, key, bytes.NewReader(value))
+	return int(n), err
+}
+
+func (g *gcsStorage) WriteStream(ctx context.Context, key string, r io.Reader) (int64, error) {
+	w := g.object(key).NewWriter(ctx)
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		_ = w.Close()
+		return 0, fmt.Errorf("gcs: error writing object %s: %w", key, err)
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, fmt.Errorf("gcs: error finalizing object %s: %w", key, err)
+	}
+
+	return n, nil
+}
+
+func (g *gcsStorage) Remove(ctx context.Context, key string) error {
+	if err := g.object(key).Delete(ctx); err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("gcs: error deleting object %s: %w", key, err)
+	}
+	return nil
+}
+
+func (g *gcsStorage) Stat(ctx context.Context, key string) (bool, error) {
+	_, err := g.object(key).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("gcs: error statting object %s: %w", key, err)
+	}
+	return true, nil
+}
+
+func (g *gcsStorage) WalkKeys(ctx context.Context, opts gostore.WalkKeysOptions) error {
+	it := g.client.Bucket(g.bucket).Objects(ctx, nil)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("gcs: error listing objects: %w", err)
+		}
+		if err := opts.WalkFn(ctx, gostore.Entry{Key: attrs.Name}); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *gcsStorage) Close() error {
+	return g.client.Close()
+}
+
+// PresignedURL generates a V4 signed URL granting read-only access
+// to key, valid for the given expiry duration.
+func (g *gcsStorage) PresignedURL(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	signed, err := g.client.Bucket(g.bucket).SignedURL(key, &storage.SignedURLOptions{
+		GoogleAccessID: g.serviceAccount,
+		Method:         "GET",
+		Expires:        time.Now().Add(expiry),
+		Scheme:         storage.SigningSchemeV4,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("gcs: error generating signed url for %s: %w", key, err)
+	}
+
+	return url.Parse(signed)
+}
+
+func (g *gcsStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	rc, err := g.object(key).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("gcs: error reading object range %s: %w", key, err)
+	}
+	return rc, nil
+}
+
+func (g *gcsStorage) StatInfo(ctx context.Context, key string) (Info, error) {
+	attrs, err := g.object(key).Attrs(ctx)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, fmt.Errorf("gcs: error statting object %s: %w", key, err)
+	}
+
+	return Info{
+		Size:        attrs.Size,
+		ModTime:     attrs.Updated,
+		ContentType: attrs.ContentType,
+	}, nil
+}
+
+// NewGCSStorage returns a new Driver backed by Google Cloud Storage,
+// using the configured bucket and (optional) credentials file.
+func NewGCSStorage() (*Driver, error) {
+	ctx := context.Background()
+
+	bucket := config.GetStorageGCSBucket()
+	credsPath := config.GetStorageGCSCredentialsPath()
+	serviceAccount := config.GetStorageGCSServiceAccount()
+
+	var opts []option.ClientOption
+	if credsPath != "" {
+		opts = append(opts, option.WithCredentialsFile(credsPath))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gcs client: %w", err)
+	}
+
+	return &Driver{
+		Proxy:  config.GetStorageGCSProxy(),
+		Bucket: bucket,
+		Storage: &gcsStorage{
+			client:         client,
+			bucket:         bucket,
+			serviceAccount: serviceAccount,
+		},
+		PresignedCache: newPresignedCache(),
+	}, nil
+}
+
+func init() {
+	RegisterBackend("gcs", NewGCSStorage)
+}
@@ -0,0 +1,123 @@
+// GoToSocial
+// Copyright (C) GoToSocial Authors admin@gotosocial.org
+// SPDX-License-Identifier: AGPL-3.0-or-later
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"sync"
+
+	gostore "codeberg.org/gruf/go-store/v2/storage"
+)
+
+// memStorage is a minimal in-memory gostore.Storage, used by this
+// package's tests in place of a real backend - it deliberately
+// implements none of PresignedURLer/RangeReader/InfoStater, so tests
+// can exercise the fallback paths those capabilities are missing from.
+type memStorage struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{data: make(map[string][]byte)}
+}
+
+func (m *memStorage) ReadBytes(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	out := make([]byte, len(v))
+	copy(out, v)
+	return out, nil
+}
+
+func (m *memStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	b, err := m.ReadBytes(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (m *memStorage) WriteBytes(ctx context.Context, key string, value []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]byte, len(value))
+	copy(out, value)
+	m.data[key] = out
+	return len(value), nil
+}
+
+func (m *memStorage) WriteStream(ctx context.Context, key string, r io.Reader) (int64, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := m.WriteBytes(ctx, key, b)
+	return int64(n), err
+}
+
+func (m *memStorage) Remove(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.data[key]; !ok {
+		return ErrNotFound
+	}
+	delete(m.data, key)
+	return nil
+}
+
+func (m *memStorage) Stat(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.data[key]
+	return ok, nil
+}
+
+func (m *memStorage) WalkKeys(ctx context.Context, opts gostore.WalkKeysOptions) error {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.data))
+	for k := range m.data {
+		keys = append(keys, k)
+	}
+	m.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := opts.WalkFn(ctx, gostore.Entry{Key: k}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memStorage) Close() error {
+	return nil
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"mime"
 	"net/url"
+	"os"
 	"path"
 	"time"
 
@@ -54,6 +55,68 @@ var (
 	ErrNotFound      = storage.ErrNotFound
 )
 
+// PresignedURLer can be implemented by a storage.Storage
+// implementation that's able to generate temporary, signed
+// GET URLs for a given key, so that callers can serve media
+// directly from the backend instead of proxying through GtS.
+//
+// Driver.URL will use this to generate presigned URLs for
+// any backend that supports it, not just S3.
+type PresignedURLer interface {
+	PresignedURL(ctx context.Context, key string, expiry time.Duration) (*url.URL, error)
+}
+
+// Info describes metadata about a stored object, without fetching
+// its contents.
+type Info struct {
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// InfoStater can be implemented by a storage.Storage implementation
+// that's able to report object metadata cheaply, without reading the
+// whole object. Driver.Stat uses this if the backend supports it.
+type InfoStater interface {
+	StatInfo(ctx context.Context, key string) (Info, error)
+}
+
+// RangeReader can be implemented by a storage.Storage implementation
+// that's able to read part of an object without fetching all of it.
+// Driver.GetRange uses this if the backend supports it, falling back
+// to fetching the whole object and slicing it in memory otherwise.
+type RangeReader interface {
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// BackendFactory creates a new storage.Storage implementation
+// for a particular backend, along with any backend-specific
+// Driver fields (Proxy, Bucket, PresignedCache, etc) that need
+// to be set on the returned Driver.
+//
+// New backends register themselves in the backends map in an
+// init() function within their own file, so that AutoConfig
+// doesn't need to know about every backend directly.
+type BackendFactory func() (*Driver, error)
+
+// backends maps a storage-backend config value (eg., "s3", "local")
+// to the BackendFactory responsible for constructing a Driver for it.
+var backends = make(map[string]BackendFactory)
+
+// RegisterBackend registers a BackendFactory under the given name,
+// so that it can be selected via the storage-backend configuration
+// value and constructed by AutoConfig. It's intended to be called
+// from the init() function of the file implementing the backend.
+//
+// RegisterBackend panics if a backend is already registered under
+// the given name, since this indicates a programming error.
+func RegisterBackend(name string, factory BackendFactory) {
+	if _, ok := backends[name]; ok {
+		panic("storage: backend already registered: " + name)
+	}
+	backends[name] = factory
+}
+
 // Driver wraps a kv.KVStore to also provide S3 presigned GET URLs.
 type Driver struct {
 	// Underlying storage
@@ -75,6 +138,53 @@ func (d *Driver) GetStream(ctx context.Context, key string) (io.ReadCloser, erro
 	return d.Storage.ReadStream(ctx, key)
 }
 
+// GetRange returns an io.ReadCloser for the value bytes at key in the
+// storage, starting at offset and reading at most length bytes. If the
+// backend doesn't support ranged reads directly, this falls back to
+// fetching the whole object and discarding/limiting around the range,
+// so callers (eg. HTTP Range request handling) don't need to know or
+// care whether the backend can do this efficiently.
+func (d *Driver) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if rr, ok := d.Storage.(RangeReader); ok {
+		return rr.GetRange(ctx, key, offset, length)
+	}
+
+	rc, err := d.Storage.ReadStream(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.CopyN(io.Discard, rc, offset); err != nil {
+		rc.Close()
+		return nil, err
+	}
+
+	return readCloser{
+		Reader: io.LimitReader(rc, length),
+		Closer: rc,
+	}, nil
+}
+
+// Stat returns size/modtime/content-type metadata for the value at
+// key in the storage, without fetching its contents. If the backend
+// doesn't support this directly, an error is returned; callers that
+// can tolerate fetching the whole object should fall back to Get.
+func (d *Driver) Stat(ctx context.Context, key string) (Info, error) {
+	is, ok := d.Storage.(InfoStater)
+	if !ok {
+		return Info{}, fmt.Errorf("storage: backend does not support Stat")
+	}
+	return is.StatInfo(ctx, key)
+}
+
+// readCloser combines a Reader and a separate Closer, so GetRange's
+// fallback path can wrap an io.LimitReader (which doesn't itself
+// implement Close) around the original stream's ReadCloser.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // Put writes the supplied value bytes at key in the storage
 func (d *Driver) Put(ctx context.Context, key string, value []byte) (int, error) {
 	return d.Storage.WriteBytes(ctx, key, value)
@@ -112,10 +222,12 @@ func (d *Driver) Close() error {
 	return d.Storage.Close()
 }
 
-// URL will return a presigned GET object URL, but only if running on S3 storage with proxying disabled.
+// URL will return a presigned GET object URL, but only if the underlying
+// storage backend supports it (via PresignedURLer) and proxying is disabled.
 func (d *Driver) URL(ctx context.Context, key string) *PresignedURL {
-	// Check whether S3 *without* proxying is enabled
-	s3, ok := d.Storage.(*storage.S3Storage)
+	// Check whether the backend supports presigned URLs,
+	// and that we're not forcing everything through the proxy.
+	signer, ok := d.Storage.(PresignedURLer)
 	if !ok || d.Proxy {
 		return nil
 	}
@@ -130,9 +242,7 @@ func (d *Driver) URL(ctx context.Context, key string) *PresignedURL {
 		return &e.Value
 	}
 
-	u, err := s3.Client().PresignedGetObject(ctx, d.Bucket, key, urlCacheTTL, url.Values{
-		"response-content-type": []string{mime.TypeByExtension(path.Ext(key))},
-	})
+	u, err := signer.PresignedURL(ctx, key, urlCacheTTL)
 	if err != nil {
 		// If URL request fails, fallback is to fetch the file. So ignore the error here
 		return nil
@@ -147,6 +257,47 @@ func (d *Driver) URL(ctx context.Context, key string) *PresignedURL {
 	return &psu
 }
 
+// s3Storage wraps a *storage.S3Storage to implement PresignedURLer,
+// so that Driver.URL can generate presigned GET URLs for S3 the
+// same way it would for any other backend that supports them.
+type s3Storage struct {
+	*storage.S3Storage
+	bucket string
+}
+
+func (s *s3Storage) PresignedURL(ctx context.Context, key string, expiry time.Duration) (*url.URL, error) {
+	return s.Client().PresignedGetObject(ctx, s.bucket, key, expiry, url.Values{
+		"response-content-type": []string{mime.TypeByExtension(path.Ext(key))},
+	})
+}
+
+func (s *s3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("s3: error setting range %d-%d for %s: %w", offset, offset+length-1, key, err)
+	}
+
+	obj, err := s.Client().GetObject(ctx, s.bucket, key, opts)
+	if err != nil {
+		return nil, fmt.Errorf("s3: error getting object range %s: %w", key, err)
+	}
+
+	return obj, nil
+}
+
+func (s *s3Storage) StatInfo(ctx context.Context, key string) (Info, error) {
+	info, err := s.Client().StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, fmt.Errorf("s3: error statting object %s: %w", key, err)
+	}
+
+	return Info{
+		Size:        info.Size,
+		ModTime:     info.LastModified,
+		ContentType: info.ContentType,
+	}, nil
+}
+
 // ProbeCSPUri returns a URI string that can be added
 // to a content-security-policy to allow requests to
 // endpoints served by this driver.
@@ -163,17 +314,17 @@ func (d *Driver) URL(ctx context.Context, key string) *PresignedURL {
 //  4. Remove the temporary file.
 //  5. Return the '[scheme]://[host]' string.
 func (d *Driver) ProbeCSPUri(ctx context.Context) (string, error) {
-	// Check whether S3 without proxying
-	// is enabled. If it's not, there's
-	// no need to add anything to the CSP.
-	s3, ok := d.Storage.(*storage.S3Storage)
+	// Check whether a backend supporting presigned
+	// URLs without proxying is enabled. If it's not,
+	// there's no need to add anything to the CSP.
+	signer, ok := d.Storage.(PresignedURLer)
 	if !ok || d.Proxy {
 		return "", nil
 	}
 
 	const cspKey = "gotosocial-csp-probe"
 
-	// Create an empty file in S3 storage.
+	// Create an empty file in storage.
 	if _, err := d.Put(ctx, cspKey, make([]byte, 0)); err != nil {
 		return "", gtserror.Newf("error putting file in bucket at key %s: %w", cspKey, err)
 	}
@@ -182,12 +333,12 @@ func (d *Driver) ProbeCSPUri(ctx context.Context) (string, error) {
 	defer func() {
 		if err := d.Delete(ctx, cspKey); err != nil {
 			log.Warnf(ctx, "error deleting file from bucket at key %s (%v); "+
-				"you may want to remove this file manually from your S3 bucket", cspKey, err)
+				"you may want to remove this file manually from your storage bucket", cspKey, err)
 		}
 	}()
 
 	// Get a presigned URL for that empty file.
-	u, err := s3.Client().PresignedGetObject(ctx, d.Bucket, cspKey, 1*time.Second, nil)
+	u, err := signer.PresignedURL(ctx, cspKey, 1*time.Second)
 	if err != nil {
 		return "", err
 	}
@@ -203,14 +354,33 @@ func (d *Driver) ProbeCSPUri(ctx context.Context) (string, error) {
 }
 
 func AutoConfig() (*Driver, error) {
-	switch backend := config.GetStorageBackend(); backend {
-	case "s3":
-		return NewS3Storage()
-	case "local":
-		return NewFileStorage()
-	default:
+	backend := config.GetStorageBackend()
+
+	factory, ok := backends[backend]
+	if !ok {
 		return nil, fmt.Errorf("invalid storage backend: %s", backend)
 	}
+
+	driver, err := factory()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := maybeWrapEncryption(driver); err != nil {
+		return nil, fmt.Errorf("error configuring storage encryption: %w", err)
+	}
+
+	// Applied after encryption, so that dedup hashes plaintext and
+	// only ever writes blobs through the encryption layer, never
+	// around it.
+	maybeWrapDedup(driver)
+
+	return driver, nil
+}
+
+func init() {
+	RegisterBackend("s3", NewS3Storage)
+	RegisterBackend("local", NewFileStorage)
 }
 
 func NewFileStorage() (*Driver, error) {
@@ -232,7 +402,51 @@ func NewFileStorage() (*Driver, error) {
 	}
 
 	return &Driver{
-		Storage: disk,
+		Storage: &localStorage{DiskStorage: disk, basePath: basePath},
+	}, nil
+}
+
+// localStorage wraps a *storage.DiskStorage to implement RangeReader
+// and InfoStater directly against the underlying file, rather than
+// reading the whole thing into memory first.
+type localStorage struct {
+	*storage.DiskStorage
+	basePath string
+}
+
+func (l *localStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(path.Join(l.basePath, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("local: error opening %s: %w", key, err)
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("local: error seeking %s: %w", key, err)
+	}
+
+	return readCloser{
+		Reader: io.LimitReader(f, length),
+		Closer: f,
+	}, nil
+}
+
+func (l *localStorage) StatInfo(ctx context.Context, key string) (Info, error) {
+	fi, err := os.Stat(path.Join(l.basePath, key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Info{}, ErrNotFound
+		}
+		return Info{}, fmt.Errorf("local: error statting %s: %w", key, err)
+	}
+
+	return Info{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
 	}, nil
 }
 
@@ -261,14 +475,20 @@ func NewS3Storage() (*Driver, error) {
 		return nil, fmt.Errorf("error opening s3 storage: %w", err)
 	}
 
-	// ttl should be lower than the expiry used by S3 to avoid serving invalid URLs
-	presignedCache := ttl.New[string, PresignedURL](0, 1000, urlCacheTTL-urlCacheExpiryFrequency)
-	presignedCache.Start(urlCacheExpiryFrequency)
-
 	return &Driver{
 		Proxy:          config.GetStorageS3Proxy(),
-		Bucket:         config.GetStorageS3BucketName(),
-		Storage:        s3,
-		PresignedCache: presignedCache,
+		Bucket:         bucket,
+		Storage:        &s3Storage{S3Storage: s3, bucket: bucket},
+		PresignedCache: newPresignedCache(),
 	}, nil
 }
+
+// newPresignedCache returns a ttl cache suitable for storing presigned
+// URLs, shared by any backend that implements PresignedURLer.
+func newPresignedCache() *ttl.Cache[string, PresignedURL] {
+	// ttl should be lower than the expiry used when generating URLs,
+	// to avoid serving invalid URLs that are about to expire.
+	c := ttl.New[string, PresignedURL](0, 1000, urlCacheTTL-urlCacheExpiryFrequency)
+	c.Start(urlCacheExpiryFrequency)
+	return c
+}